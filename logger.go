@@ -0,0 +1,177 @@
+package libp2praft
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-hclog"
+	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+)
+
+var raftLogger = logging.Logger("raftlib")
+
+// hcLogToLogger adapts a go-log/v2 *zap.SugaredLogger to hclog.Logger so
+// it can be handed to raft.NetworkTransportConfig.Logger. It honors
+// SetLevel, keeps structured key/value pairs intact instead of
+// flattening them into a %s-joined string, and composes With/Named
+// prefixes and fields immutably: calling With or Named on a logger
+// never changes what the parent logs.
+type hcLogToLogger struct {
+	sugar *zap.SugaredLogger
+	name  string
+	level atomic.Int32
+}
+
+// newHCLogger wraps base as an hclog.Logger. The returned logger starts
+// at hclog.Trace, i.e. everything is logged, matching this package's
+// prior behavior; call SetLevel to restrict it.
+func newHCLogger(base *zap.SugaredLogger) *hcLogToLogger {
+	l := &hcLogToLogger{sugar: base}
+	l.level.Store(int32(hclog.Trace))
+	return l
+}
+
+func (l *hcLogToLogger) enabled(level hclog.Level) bool {
+	if level == hclog.NoLevel {
+		level = hclog.Info
+	}
+	return level >= hclog.Level(l.level.Load())
+}
+
+func (l *hcLogToLogger) prefixed(msg string) string {
+	if l.name == "" {
+		return msg
+	}
+	return l.name + ": " + msg
+}
+
+func (l *hcLogToLogger) log(level hclog.Level, msg string, args []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	msg = l.prefixed(msg)
+	switch {
+	case level <= hclog.Debug:
+		l.sugar.Debugw(msg, args...)
+	case level == hclog.Info:
+		l.sugar.Infow(msg, args...)
+	case level == hclog.Warn:
+		l.sugar.Warnw(msg, args...)
+	default:
+		l.sugar.Errorw(msg, args...)
+	}
+}
+
+func (l *hcLogToLogger) Log(level hclog.Level, msg string, args ...interface{}) {
+	l.log(level, msg, args)
+}
+
+func (l *hcLogToLogger) Trace(msg string, args ...interface{}) {
+	l.log(hclog.Trace, msg, args)
+}
+
+func (l *hcLogToLogger) Debug(msg string, args ...interface{}) {
+	l.log(hclog.Debug, msg, args)
+}
+
+func (l *hcLogToLogger) Info(msg string, args ...interface{}) {
+	l.log(hclog.Info, msg, args)
+}
+
+func (l *hcLogToLogger) Warn(msg string, args ...interface{}) {
+	l.log(hclog.Warn, msg, args)
+}
+
+func (l *hcLogToLogger) Error(msg string, args ...interface{}) {
+	l.log(hclog.Error, msg, args)
+}
+
+func (l *hcLogToLogger) IsTrace() bool { return l.enabled(hclog.Trace) }
+func (l *hcLogToLogger) IsDebug() bool { return l.enabled(hclog.Debug) }
+func (l *hcLogToLogger) IsInfo() bool  { return l.enabled(hclog.Info) }
+func (l *hcLogToLogger) IsWarn() bool  { return l.enabled(hclog.Warn) }
+func (l *hcLogToLogger) IsError() bool { return l.enabled(hclog.Error) }
+
+// GetLevel returns the minimum level l currently logs at.
+func (l *hcLogToLogger) GetLevel() hclog.Level {
+	return hclog.Level(l.level.Load())
+}
+
+func (l *hcLogToLogger) Name() string {
+	return l.name
+}
+
+// clone returns a new logger with l's sugar, name, and level, for With/
+// Named/ResetNamed to adjust without affecting l. It builds a fresh
+// struct rather than dereferencing l, since level is an atomic.Int32
+// and copying one directly trips go vet's copylocks check.
+func (l *hcLogToLogger) clone() *hcLogToLogger {
+	child := &hcLogToLogger{sugar: l.sugar, name: l.name}
+	child.level.Store(l.level.Load())
+	return child
+}
+
+// With returns a child logger that prepends args to every subsequent
+// call's key/value pairs, without affecting l. Field accumulation is
+// delegated to the underlying SugaredLogger, which preserves types
+// instead of formatting them into a string.
+func (l *hcLogToLogger) With(args ...interface{}) hclog.Logger {
+	child := l.clone()
+	child.sugar = l.sugar.With(args...)
+	return child
+}
+
+// Named returns a child logger whose name is l's name and name joined
+// with ".", without affecting l.
+func (l *hcLogToLogger) Named(name string) hclog.Logger {
+	child := l.clone()
+	if child.name != "" {
+		child.name = child.name + "." + name
+	} else {
+		child.name = name
+	}
+	return child
+}
+
+// ResetNamed returns a child logger with name as its name, discarding
+// any name l had, without affecting l.
+func (l *hcLogToLogger) ResetNamed(name string) hclog.Logger {
+	child := l.clone()
+	child.name = name
+	return child
+}
+
+// SetLevel changes the minimum level l logs at. It mutates l in place,
+// matching hclog.Logger's documented behavior; level is read with
+// atomic.Int32 rather than a bare field since raft.NetworkTransport logs
+// from multiple goroutines concurrently with any call to SetLevel.
+func (l *hcLogToLogger) SetLevel(level hclog.Level) {
+	l.level.Store(int32(level))
+}
+
+func (l *hcLogToLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+func (l *hcLogToLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return &hcLogWriter{l: l}
+}
+
+// ImpliedArgs is not supported: the key/value pairs accumulated via With
+// live inside the wrapped *zap.SugaredLogger, which does not expose them
+// back out.
+func (l *hcLogToLogger) ImpliedArgs() []interface{} {
+	return nil
+}
+
+type hcLogWriter struct {
+	l *hcLogToLogger
+}
+
+func (w *hcLogWriter) Write(p []byte) (int, error) {
+	w.l.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,72 @@
+package libp2praft
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newObservedHCLogger() (*hcLogToLogger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	sugar := zap.New(core).Sugar()
+	return newHCLogger(sugar), logs
+}
+
+func TestHCLogToLoggerOddArgsDoesNotPanic(t *testing.T) {
+	l, logs := newObservedHCLogger()
+
+	l.Info("odd number of args", "key")
+
+	if logs.Len() == 0 {
+		t.Fatal("expected a log entry even with an odd-length args slice")
+	}
+}
+
+func TestHCLogToLoggerLevelFiltering(t *testing.T) {
+	l, logs := newObservedHCLogger()
+	l.SetLevel(hclog.Warn)
+
+	l.Debug("should be filtered")
+	l.Info("should also be filtered")
+	if logs.Len() != 0 {
+		t.Fatalf("expected Debug/Info to be filtered out at Warn level, got %d entries", logs.Len())
+	}
+
+	l.Warn("should pass")
+	l.Error("should also pass")
+	if logs.Len() != 2 {
+		t.Fatalf("expected Warn and Error to pass at Warn level, got %d entries", logs.Len())
+	}
+}
+
+func TestHCLogToLoggerWithPreservesParent(t *testing.T) {
+	l, logs := newObservedHCLogger()
+
+	child := l.With("request", "abc").(*hcLogToLogger)
+	child.Info("child message")
+	l.Info("parent message")
+
+	if logs.Len() != 2 {
+		t.Fatalf("expected both parent and child messages to be logged, got %d entries", logs.Len())
+	}
+
+	entry := logs.All()[0]
+	found := false
+	for _, f := range entry.Context {
+		if f.Key == "request" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected the child logger's message to carry the request field as structured context")
+	}
+
+	for _, f := range logs.All()[1].Context {
+		if f.Key == "request" {
+			t.Fatal("expected the parent logger's message not to carry the child's request field")
+		}
+	}
+}
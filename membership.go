@@ -0,0 +1,179 @@
+package libp2praft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// MembershipProtocol is the libp2p protocol ID used by Membership to
+// request cluster configuration changes. A joining node can contact any
+// existing member; a non-leader member forwards the request by naming
+// the current leader in its response, and the caller retries there.
+const MembershipProtocol protocol.ID = "/raft/1.0.0/membership"
+
+type membershipRequest struct {
+	Op      string // "join" or "leave"
+	ID      string // raft.ServerID of the requesting node
+	Address string // raft.ServerAddress (a peer.ID) of the requesting node
+}
+
+type membershipResponse struct {
+	Err string
+	// LeaderHint, set when Err is raft.ErrNotLeader's message, is the
+	// peer ID of the node the caller should retry the request against.
+	LeaderHint string
+}
+
+// Membership layers a join/leave workflow on top of a *raft.Raft and the
+// libp2p transport it was built with, so applications don't need to
+// hand-roll configuration-change RPCs on top of hashicorp/raft.
+type Membership struct {
+	host    host.Host
+	raft    *raft.Raft
+	self    raft.ServerID
+	timeout time.Duration
+}
+
+// NewMembership registers a MembershipProtocol handler on h that serves
+// join/leave requests on behalf of r, and returns a Membership that self
+// can use to join or leave the cluster r participates in. h must be the
+// same host the transport r was built with uses.
+func NewMembership(h host.Host, r *raft.Raft, self raft.ServerID, timeout time.Duration) *Membership {
+	m := &Membership{host: h, raft: r, self: self, timeout: timeout}
+	h.SetStreamHandler(MembershipProtocol, m.handleStream)
+	return m
+}
+
+func (m *Membership) handleStream(s network.Stream) {
+	defer s.Close()
+
+	var req membershipRequest
+	if err := json.NewDecoder(s).Decode(&req); err != nil {
+		s.Reset()
+		return
+	}
+
+	json.NewEncoder(s).Encode(m.apply(req))
+}
+
+func (m *Membership) apply(req membershipRequest) membershipResponse {
+	if m.raft.State() != raft.Leader {
+		return membershipResponse{
+			Err:        raft.ErrNotLeader.Error(),
+			LeaderHint: string(m.raft.Leader()),
+		}
+	}
+
+	var future raft.IndexFuture
+	switch req.Op {
+	case "join":
+		future = m.raft.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.Address), 0, m.timeout)
+	case "leave":
+		future = m.raft.RemoveServer(raft.ServerID(req.ID), 0, m.timeout)
+	default:
+		return membershipResponse{Err: fmt.Sprintf("unknown membership op %q", req.Op)}
+	}
+
+	if err := future.Error(); err != nil {
+		return membershipResponse{Err: err.Error()}
+	}
+	return membershipResponse{}
+}
+
+// JoinAsVoter asks contact, any existing member of the cluster, to add
+// this node as a voter. If contact is not the leader, the request is
+// forwarded to whichever peer it names as the current leader and
+// retried there, until ctx is done.
+func (m *Membership) JoinAsVoter(ctx context.Context, contact peer.ID) error {
+	return m.request(ctx, contact, membershipRequest{
+		Op:      "join",
+		ID:      string(m.self),
+		Address: m.host.ID().String(),
+	})
+}
+
+// LeaveCluster asks contact to remove this node from the cluster
+// configuration, retrying against the leader the same way JoinAsVoter
+// does.
+func (m *Membership) LeaveCluster(ctx context.Context, contact peer.ID) error {
+	return m.request(ctx, contact, membershipRequest{
+		Op: "leave",
+		ID: string(m.self),
+	})
+}
+
+// noLeaderRetryInterval is how long request waits before retrying
+// against the same contact when that contact doesn't yet know who the
+// leader is, e.g. mid-election on a freshly bootstrapping cluster.
+const noLeaderRetryInterval = 200 * time.Millisecond
+
+func (m *Membership) request(ctx context.Context, contact peer.ID, req membershipRequest) error {
+	for {
+		resp, err := m.send(ctx, contact, req)
+		if err != nil {
+			return err
+		}
+
+		if resp.Err == "" {
+			return nil
+		}
+		if resp.Err != raft.ErrNotLeader.Error() {
+			return errors.New(resp.Err)
+		}
+
+		if resp.LeaderHint == "" {
+			// contact doesn't know the leader yet; wait for an election
+			// to settle and retry against the same contact rather than
+			// failing a request made while the cluster is mid-election.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(noLeaderRetryInterval):
+			}
+			continue
+		}
+
+		hint, err := peer.Decode(resp.LeaderHint)
+		if err != nil {
+			return fmt.Errorf("leader hint %q is not a valid peer ID: %w", resp.LeaderHint, err)
+		}
+		contact = hint
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+}
+
+func (m *Membership) send(ctx context.Context, contact peer.ID, req membershipRequest) (membershipResponse, error) {
+	s, err := gostream.Dial(ctx, m.host, contact, MembershipProtocol)
+	if err != nil {
+		return membershipResponse{}, err
+	}
+	defer s.Close()
+
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		return membershipResponse{}, err
+	}
+	if cw, ok := s.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+
+	var resp membershipResponse
+	if err := json.NewDecoder(s).Decode(&resp); err != nil {
+		return membershipResponse{}, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,66 @@
+package libp2praft
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// TestMembershipRequestRetriesOnEmptyLeaderHint exercises request()'s
+// retry loop against a fake contact that doesn't know the leader yet
+// for its first two requests, mimicking a cluster that's mid-election,
+// then succeeds.
+func TestMembershipRequestRetriesOnEmptyLeaderHint(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	contact, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer contact: %v", err)
+	}
+	client, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer client: %v", err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatalf("LinkAll: %v", err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatalf("ConnectAllButSelf: %v", err)
+	}
+
+	var calls int32
+	contact.SetStreamHandler(MembershipProtocol, func(s network.Stream) {
+		defer s.Close()
+
+		var req membershipRequest
+		if err := json.NewDecoder(s).Decode(&req); err != nil {
+			s.Reset()
+			return
+		}
+
+		resp := membershipResponse{}
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			resp = membershipResponse{Err: raft.ErrNotLeader.Error()}
+		}
+		json.NewEncoder(s).Encode(resp)
+	})
+
+	m := &Membership{host: client, self: raft.ServerID("node1"), timeout: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.JoinAsVoter(ctx, contact.ID()); err != nil {
+		t.Fatalf("JoinAsVoter: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got < 3 {
+		t.Fatalf("expected request() to retry against contact until it succeeded, got %d calls", got)
+	}
+}
@@ -0,0 +1,203 @@
+package libp2praft
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Channel labels which protocol a stream an Observer is told about
+// belongs to. The stream layer has no notion of individual Raft RPC
+// types (raft.StreamLayer.Dial is called generically for every RPC sent
+// on RaftProtocol), so this is the coarsest breakdown that's actually
+// meaningful: RPCs handled generically versus snapshot transfers, which
+// NewLibp2pTransportWithSnapshotChannel moves onto their own protocol.
+type Channel string
+
+const (
+	// ChannelRPC is RaftProtocol traffic: AppendEntries, RequestVote,
+	// TimeoutNow, and, unless NewLibp2pTransportWithSnapshotChannel was
+	// used, InstallSnapshot.
+	ChannelRPC Channel = "rpc"
+	// ChannelSnapshot is SnapshotProtocol traffic: InstallSnapshot RPCs
+	// and manual transfers, when a transport was built with
+	// NewLibp2pTransportWithSnapshotChannel.
+	ChannelSnapshot Channel = "snapshot"
+)
+
+// Observer receives instrumentation events from the transport's stream
+// layer: bytes sent/received, stream open/close counts, and dial
+// latency, labeled by remote peer and Channel. Without it, diagnosing a
+// slow follower on top of libp2p has no per-peer signal to look at.
+type Observer interface {
+	// StreamOpened is called when a stream to p on ch is established,
+	// either by Dial or by Accept. dialLatency is the time Dial took to
+	// establish it, or zero for a stream obtained via Accept.
+	StreamOpened(p peer.ID, ch Channel, dialLatency time.Duration)
+	// StreamClosed is called when a stream to p on ch is closed.
+	StreamClosed(p peer.ID, ch Channel)
+	// BytesSent is called with the number of bytes written to a stream to p on ch.
+	BytesSent(p peer.ID, ch Channel, n int)
+	// BytesReceived is called with the number of bytes read from a stream to p on ch.
+	BytesReceived(p peer.ID, ch Channel, n int)
+}
+
+// observedConn wraps a net.Conn to a given peer and channel so reads,
+// writes, and closes are reported to an Observer.
+type observedConn struct {
+	net.Conn
+	peer     peer.ID
+	channel  Channel
+	observer Observer
+}
+
+func observeConn(conn net.Conn, p peer.ID, ch Channel, observer Observer) net.Conn {
+	if observer == nil {
+		return conn
+	}
+	return &observedConn{Conn: conn, peer: p, channel: ch, observer: observer}
+}
+
+func (c *observedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.observer.BytesReceived(c.peer, c.channel, n)
+	}
+	return n, err
+}
+
+func (c *observedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.observer.BytesSent(c.peer, c.channel, n)
+	}
+	return n, err
+}
+
+func (c *observedConn) Close() error {
+	c.observer.StreamClosed(c.peer, c.channel)
+	return c.Conn.Close()
+}
+
+// observedReader wraps an io.Reader so bytes read through it are
+// reported to an Observer. Used where a full net.Conn isn't available
+// to wrap, e.g. the io.Reader raft.RPC hands a snapshot's body through.
+type observedReader struct {
+	io.Reader
+	peer     peer.ID
+	channel  Channel
+	observer Observer
+}
+
+func observeReader(r io.Reader, p peer.ID, ch Channel, observer Observer) io.Reader {
+	if observer == nil {
+		return r
+	}
+	return &observedReader{Reader: r, peer: p, channel: ch, observer: observer}
+}
+
+func (r *observedReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.observer.BytesReceived(r.peer, r.channel, n)
+	}
+	return n, err
+}
+
+// observedReadCloser is observedReader plus a Close that reports
+// StreamClosed, for handing an observed stream out as an io.ReadCloser.
+type observedReadCloser struct {
+	observedReader
+	closer io.Closer
+}
+
+func observeReadCloser(rc io.ReadCloser, p peer.ID, ch Channel, observer Observer) io.ReadCloser {
+	if observer == nil {
+		return rc
+	}
+	return &observedReadCloser{
+		observedReader: observedReader{Reader: rc, peer: p, channel: ch, observer: observer},
+		closer:         rc,
+	}
+}
+
+func (rc *observedReadCloser) Close() error {
+	rc.observer.StreamClosed(rc.peer, rc.channel)
+	return rc.closer.Close()
+}
+
+// otelObserver is the default Observer, recording every event through
+// an OpenTelemetry meter.
+type otelObserver struct {
+	bytesSent     metric.Int64Counter
+	bytesReceived metric.Int64Counter
+	streamsOpened metric.Int64Counter
+	streamsClosed metric.Int64Counter
+	dialLatency   metric.Float64Histogram
+}
+
+// NewOTelObserver builds an Observer that records transport metrics
+// through meter, with every measurement labeled by the remote peer ID
+// and Channel.
+func NewOTelObserver(meter metric.Meter) (Observer, error) {
+	bytesSent, err := meter.Int64Counter("raft_libp2p_bytes_sent")
+	if err != nil {
+		return nil, err
+	}
+	bytesReceived, err := meter.Int64Counter("raft_libp2p_bytes_received")
+	if err != nil {
+		return nil, err
+	}
+	streamsOpened, err := meter.Int64Counter("raft_libp2p_streams_opened")
+	if err != nil {
+		return nil, err
+	}
+	streamsClosed, err := meter.Int64Counter("raft_libp2p_streams_closed")
+	if err != nil {
+		return nil, err
+	}
+	dialLatency, err := meter.Float64Histogram("raft_libp2p_dial_latency_seconds")
+	if err != nil {
+		return nil, err
+	}
+
+	return &otelObserver{
+		bytesSent:     bytesSent,
+		bytesReceived: bytesReceived,
+		streamsOpened: streamsOpened,
+		streamsClosed: streamsClosed,
+		dialLatency:   dialLatency,
+	}, nil
+}
+
+func attrs(p peer.ID, ch Channel) metric.MeasurementOption {
+	return metric.WithAttributes(
+		attribute.String("peer", p.String()),
+		attribute.String("channel", string(ch)),
+	)
+}
+
+func (o *otelObserver) StreamOpened(p peer.ID, ch Channel, dialLatency time.Duration) {
+	ctx := context.Background()
+	o.streamsOpened.Add(ctx, 1, attrs(p, ch))
+	if dialLatency > 0 {
+		o.dialLatency.Record(ctx, dialLatency.Seconds(), attrs(p, ch))
+	}
+}
+
+func (o *otelObserver) StreamClosed(p peer.ID, ch Channel) {
+	o.streamsClosed.Add(context.Background(), 1, attrs(p, ch))
+}
+
+func (o *otelObserver) BytesSent(p peer.ID, ch Channel, n int) {
+	o.bytesSent.Add(context.Background(), int64(n), attrs(p, ch))
+}
+
+func (o *otelObserver) BytesReceived(p peer.ID, ch Channel, n int) {
+	o.bytesReceived.Add(context.Background(), int64(n), attrs(p, ch))
+}
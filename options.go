@@ -0,0 +1,126 @@
+package libp2praft
+
+import (
+	"github.com/hashicorp/go-hclog"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ConnAuthorizer decides whether a Raft RPC stream to or from the given
+// peer should be allowed. It is consulted for both outbound Dials and
+// inbound Accepts, in addition to (not instead of) any allowlist
+// configured via WithAllowedPeers.
+type ConnAuthorizer func(p peer.ID) bool
+
+// transportConfig collects the options accepted by NewLibp2pTransport and
+// its sibling constructors.
+type transportConfig struct {
+	allowedPeers map[peer.ID]struct{}
+	authorizer   ConnAuthorizer
+	poolSize     int
+	logger       hclog.Logger
+	observer     Observer
+}
+
+// TransportOption configures a transport returned by NewLibp2pTransport.
+type TransportOption func(*transportConfig)
+
+// WithAllowedPeers restricts RaftProtocol to the given set of peer IDs;
+// connections to or from anyone else are rejected before they reach
+// raft.NetworkTransport, in both directions.
+//
+// WithAllowedPeers can be combined with WithConnAuthorizer: a connection
+// must pass both checks to be established.
+func WithAllowedPeers(peers ...peer.ID) TransportOption {
+	return func(c *transportConfig) {
+		if c.allowedPeers == nil {
+			c.allowedPeers = make(map[peer.ID]struct{}, len(peers))
+		}
+		for _, p := range peers {
+			c.allowedPeers[p] = struct{}{}
+		}
+	}
+}
+
+// WithConnAuthorizer installs a callback invoked for every peer attempting
+// to Dial or Accept a Raft RPC stream. Returning false rejects the
+// connection. Unlike WithAllowedPeers, which is a static set, this lets
+// applications authorize against state that changes over time, such as
+// the current Raft configuration.
+func WithConnAuthorizer(authz ConnAuthorizer) TransportOption {
+	return func(c *transportConfig) {
+		c.authorizer = authz
+	}
+}
+
+// WithPoolSize keeps up to n idle libp2p streams open per remote peer
+// once they have been used, and reuses them for outbound RPCs instead of
+// opening a fresh stream for every AppendEntries or heartbeat call. No
+// streams are opened ahead of use. Opening a libp2p stream is
+// cheap compared to a TCP connection, but under load newly opened
+// streams still serialize and contend with each other for the same
+// remote.
+//
+// n is passed straight through to raft.NetworkTransportConfig.MaxPool,
+// so n <= 0 disables pooling, matching the transport's historical
+// default. This is deliberately scoped down from a per-RPC-class stream
+// router: raft.NetworkTransport pools by remote address only and has no
+// notion of RPC class, so WithPoolSize cannot by itself keep, say,
+// AppendEntries off a stream a heartbeat is also using. Building that
+// would mean reimplementing raft.NetworkTransport's pipelined
+// AppendEntries protocol by hand, which risks real consensus bugs for a
+// throughput optimization; it is not attempted here. Snapshot transfers,
+// the one RPC class big enough to cause real head-of-line blocking, are
+// instead moved off this pool entirely by
+// NewLibp2pTransportWithSnapshotChannel.
+func WithPoolSize(n int) TransportOption {
+	return func(c *transportConfig) {
+		c.poolSize = n
+	}
+}
+
+// WithLogger overrides the hclog.Logger the underlying
+// raft.NetworkTransport logs through. Applications already standardized
+// on a particular logging backend can supply their own hclog.Logger
+// (e.g. an adapter over zerolog or zap) instead of routing Raft's log
+// output through go-log/v2.
+func WithLogger(logger hclog.Logger) TransportOption {
+	return func(c *transportConfig) {
+		c.logger = logger
+	}
+}
+
+// WithObserver installs an Observer that the transport's stream layer
+// reports bytes sent/received, stream open/close counts, and dial
+// latency to, labeled by remote peer. See NewOTelObserver for a default,
+// OpenTelemetry-backed implementation.
+func WithObserver(observer Observer) TransportOption {
+	return func(c *transportConfig) {
+		c.observer = observer
+	}
+}
+
+func newTransportConfig(opts []TransportOption) *transportConfig {
+	cfg := &transportConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// authorize reports whether p may open or accept a Raft RPC stream under
+// this configuration. A config with no allowlist and no authorizer
+// permits everyone, preserving the transport's default behavior.
+func (c *transportConfig) authorize(p peer.ID) bool {
+	if c == nil {
+		return true
+	}
+	if c.allowedPeers != nil {
+		if _, ok := c.allowedPeers[p]; !ok {
+			return false
+		}
+	}
+	if c.authorizer != nil && !c.authorizer(p) {
+		return false
+	}
+	return true
+}
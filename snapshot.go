@@ -0,0 +1,409 @@
+package libp2praft
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// SnapshotProtocol is the libp2p protocol ID snapshot traffic travels
+// on, separate from RaftProtocol, so a large snapshot transfer cannot
+// occupy the same stream as heartbeats and log replication.
+const SnapshotProtocol protocol.ID = "/raft/1.0.0/snapshot"
+
+// snapshotStreamKind is the first byte written on every SnapshotProtocol
+// stream, telling the receiver which of SnapshotChannel's two framings
+// to expect for the rest of the stream.
+type snapshotStreamKind byte
+
+const (
+	// snapshotStreamTransfer carries a manual, application-driven
+	// transfer started with SnapshotChannel.Send: an int64 offset
+	// followed by raw bytes.
+	snapshotStreamTransfer snapshotStreamKind = iota
+	// snapshotStreamInstall carries a real raft InstallSnapshot RPC,
+	// routed here by snapshotRoutingTransport: a framed
+	// *raft.InstallSnapshotRequest, the snapshot bytes it describes,
+	// then a framed *raft.InstallSnapshotResponse.
+	snapshotStreamInstall
+)
+
+// NewLibp2pTransportWithSnapshotChannel behaves like NewLibp2pTransport,
+// except InstallSnapshot RPCs are sent and received over SnapshotProtocol
+// instead of RaftProtocol. It also returns a SnapshotChannel applications
+// can use to push or pull snapshot bytes directly between peers outside
+// of Raft's own InstallSnapshot flow, e.g. to pre-seed a joining
+// follower. The returned raft.Transport should be passed to raft.NewRaft
+// in place of a plain *raft.NetworkTransport.
+func NewLibp2pTransportWithSnapshotChannel(h host.Host, timeout time.Duration, opts ...TransportOption) (raft.Transport, *SnapshotChannel, error) {
+	nt, err := NewLibp2pTransport(h, timeout, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sc := newSnapshotChannel(h, newTransportConfig(opts), timeout)
+	trans := newSnapshotRoutingTransport(nt, sc, timeout)
+	return trans, sc, nil
+}
+
+// snapshotRoutingTransport is a raft.Transport identical to the embedded
+// *raft.NetworkTransport, except InstallSnapshot is overridden to run
+// over a SnapshotChannel instead of the embedded transport's own
+// RaftProtocol stream. Consumer is overridden to match, fanning in RPCs
+// delivered either way so raft.Raft sees a single RPC stream regardless
+// of which protocol a request arrived on.
+type snapshotRoutingTransport struct {
+	*raft.NetworkTransport
+	sc        *SnapshotChannel
+	timeout   time.Duration
+	consumer  chan raft.RPC
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSnapshotRoutingTransport(nt *raft.NetworkTransport, sc *SnapshotChannel, timeout time.Duration) *snapshotRoutingTransport {
+	t := &snapshotRoutingTransport{
+		NetworkTransport: nt,
+		sc:               sc,
+		timeout:          timeout,
+		consumer:         make(chan raft.RPC, 16),
+		done:             make(chan struct{}),
+	}
+	sc.rpcCh = t.consumer
+	sc.doneCh = t.done
+	go t.fanInGenericRPCs()
+	return t
+}
+
+// fanInGenericRPCs forwards RPCs the embedded NetworkTransport received
+// on RaftProtocol into t.consumer, the single channel Consumer returns.
+// InstallSnapshot RPCs received on SnapshotProtocol are pushed directly
+// onto t.consumer by SnapshotChannel's stream handler instead.
+func (t *snapshotRoutingTransport) fanInGenericRPCs() {
+	for {
+		select {
+		case rpc, ok := <-t.NetworkTransport.Consumer():
+			if !ok {
+				return
+			}
+			select {
+			case t.consumer <- rpc:
+			case <-t.done:
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *snapshotRoutingTransport) Consumer() <-chan raft.RPC {
+	return t.consumer
+}
+
+func (t *snapshotRoutingTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	t.sc.Close()
+	return t.NetworkTransport.Close()
+}
+
+func (t *snapshotRoutingTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	p, err := peer.Decode(string(target))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	return t.sc.sendInstallSnapshot(ctx, p, args, resp, data)
+}
+
+// SnapshotTransfer is an inbound manual snapshot byte stream accepted by
+// SnapshotChannel.Accept. Callers should Read from it until io.EOF and
+// must Close it when done.
+type SnapshotTransfer struct {
+	// From is the peer sending the snapshot.
+	From peer.ID
+	// Offset is the byte offset the sender started at, allowing a
+	// transfer to resume a previous, partially completed one.
+	Offset int64
+
+	io.ReadCloser
+}
+
+// SnapshotChannel transfers snapshot bytes to and from peers over
+// SnapshotProtocol: both the manual transfers started with Send, and the
+// real raft InstallSnapshot RPCs routed to it by
+// NewLibp2pTransportWithSnapshotChannel's snapshotRoutingTransport.
+type SnapshotChannel struct {
+	host          host.Host
+	cfg           *transportConfig
+	acceptTimeout time.Duration
+
+	incoming chan *SnapshotTransfer
+	rpcCh    chan<- raft.RPC
+	// doneCh, when set by newSnapshotRoutingTransport, lets
+	// handleInstallSnapshot stop waiting on a routed RPC's response if
+	// the transport is closed before raft.Raft's main loop answers it,
+	// the same shutdown escape hatch raft.NetworkTransport's own stream
+	// handler uses instead of a generic timeout.
+	doneCh <-chan struct{}
+}
+
+func newSnapshotChannel(h host.Host, cfg *transportConfig, acceptTimeout time.Duration) *SnapshotChannel {
+	sc := &SnapshotChannel{
+		host:          h,
+		cfg:           cfg,
+		acceptTimeout: acceptTimeout,
+		incoming:      make(chan *SnapshotTransfer),
+	}
+	h.SetStreamHandler(SnapshotProtocol, sc.handleStream)
+	return sc
+}
+
+// Close stops sc from accepting any further SnapshotProtocol streams.
+// It does not affect transfers already in flight.
+func (sc *SnapshotChannel) Close() error {
+	sc.host.RemoveStreamHandler(SnapshotProtocol)
+	return nil
+}
+
+func (sc *SnapshotChannel) handleStream(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !sc.cfg.authorize(remote) {
+		raftLogger.Warnf("rejecting unauthorized connection on %s from %s", SnapshotProtocol, remote)
+		s.Reset()
+		return
+	}
+
+	obs := sc.cfg.observer
+	if obs != nil {
+		obs.StreamOpened(remote, ChannelSnapshot, 0)
+	}
+
+	var kind [1]byte
+	if _, err := io.ReadFull(s, kind[:]); err != nil {
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+		return
+	}
+
+	switch snapshotStreamKind(kind[0]) {
+	case snapshotStreamInstall:
+		sc.handleInstallSnapshot(s, remote, obs)
+	default:
+		sc.handleTransfer(s, remote, obs)
+	}
+}
+
+func (sc *SnapshotChannel) handleTransfer(s network.Stream, remote peer.ID, obs Observer) {
+	var offset int64
+	if err := binary.Read(s, binary.BigEndian, &offset); err != nil {
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+		return
+	}
+
+	t := &SnapshotTransfer{From: remote, Offset: offset, ReadCloser: observeReadCloser(s, remote, ChannelSnapshot, obs)}
+	select {
+	case sc.incoming <- t:
+	case <-time.After(sc.acceptTimeout):
+		raftLogger.Warnf("dropping snapshot transfer from %s: nothing called Accept within %s", remote, sc.acceptTimeout)
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+	}
+}
+
+// handleInstallSnapshot decodes a raft InstallSnapshotRequest off s and
+// hands it to whatever is reading rpcCh (snapshotRoutingTransport's
+// Consumer, ultimately raft.Raft's main loop) as an ordinary raft.RPC,
+// then writes back whatever response that loop produces. If nothing
+// reads rpcCh within acceptTimeout the stream is reset instead of
+// leaking a goroutine blocked on the send forever.
+func (sc *SnapshotChannel) handleInstallSnapshot(s network.Stream, remote peer.ID, obs Observer) {
+	var req raft.InstallSnapshotRequest
+	if err := readMsg(s, &req); err != nil {
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+		return
+	}
+
+	respCh := make(chan raft.RPCResponse, 1)
+	rpc := raft.RPC{
+		Command:  &req,
+		Reader:   io.LimitReader(observeReader(s, remote, ChannelSnapshot, obs), req.Size),
+		RespChan: respCh,
+	}
+
+	select {
+	case sc.rpcCh <- rpc:
+	case <-time.After(sc.acceptTimeout):
+		raftLogger.Warnf("dropping InstallSnapshot from %s: transport consumer did not read within %s", remote, sc.acceptTimeout)
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+		return
+	}
+
+	var out raft.RPCResponse
+	select {
+	case out = <-respCh:
+	case <-sc.doneCh:
+		raftLogger.Warnf("dropping InstallSnapshot from %s: transport closed before raft responded", remote)
+		if obs != nil {
+			obs.StreamClosed(remote, ChannelSnapshot)
+		}
+		s.Reset()
+		return
+	}
+	resp, _ := out.Response.(*raft.InstallSnapshotResponse)
+	if resp == nil {
+		resp = &raft.InstallSnapshotResponse{}
+	}
+
+	err := writeMsg(s, resp)
+	if obs != nil {
+		obs.StreamClosed(remote, ChannelSnapshot)
+	}
+	if err != nil {
+		s.Reset()
+		return
+	}
+	s.Close()
+}
+
+// Accept blocks until a peer starts a manual transfer via Send, or ctx
+// is done.
+func (sc *SnapshotChannel) Accept(ctx context.Context) (*SnapshotTransfer, error) {
+	select {
+	case t := <-sc.incoming:
+		return t, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send streams r to p as a manual transfer labeled with offset, which the
+// receiver sees as SnapshotTransfer.Offset, so a previously interrupted
+// transfer can resume without re-sending bytes the peer already has.
+// Send does not skip any of r itself: r must yield the snapshot's bytes
+// from offset onward. Pass offset 0 to send from the beginning. The
+// peer must be reading via Accept for this to complete; it is not used
+// for raft's own InstallSnapshot RPCs, which go through
+// snapshotRoutingTransport instead.
+func (sc *SnapshotChannel) Send(ctx context.Context, p peer.ID, offset int64, r io.Reader) error {
+	if !sc.cfg.authorize(p) {
+		return fmt.Errorf("peer %s is not authorized for %s", p, SnapshotProtocol)
+	}
+
+	start := time.Now()
+	s, err := gostream.Dial(ctx, sc.host, p, SnapshotProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	obs := sc.cfg.observer
+	if obs != nil {
+		obs.StreamOpened(p, ChannelSnapshot, time.Since(start))
+		defer obs.StreamClosed(p, ChannelSnapshot)
+	}
+
+	if _, err := s.Write([]byte{byte(snapshotStreamTransfer)}); err != nil {
+		return err
+	}
+	if err := binary.Write(s, binary.BigEndian, offset); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(s, r)
+	if obs != nil && n > 0 {
+		obs.BytesSent(p, ChannelSnapshot, int(n))
+	}
+	return err
+}
+
+func (sc *SnapshotChannel) sendInstallSnapshot(ctx context.Context, p peer.ID, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	if !sc.cfg.authorize(p) {
+		return fmt.Errorf("peer %s is not authorized for %s", p, SnapshotProtocol)
+	}
+
+	start := time.Now()
+	s, err := gostream.Dial(ctx, sc.host, p, SnapshotProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	obs := sc.cfg.observer
+	if obs != nil {
+		obs.StreamOpened(p, ChannelSnapshot, time.Since(start))
+		defer obs.StreamClosed(p, ChannelSnapshot)
+	}
+
+	if _, err := s.Write([]byte{byte(snapshotStreamInstall)}); err != nil {
+		return err
+	}
+	if err := writeMsg(s, args); err != nil {
+		return err
+	}
+	n, err := io.CopyN(s, data, args.Size)
+	if obs != nil && n > 0 {
+		obs.BytesSent(p, ChannelSnapshot, int(n))
+	}
+	if err != nil {
+		return err
+	}
+
+	return readMsg(s, resp)
+}
+
+// writeMsg gob-encodes v and writes it to w as a 4-byte big-endian
+// length prefix followed by the encoded payload, so the receiver can
+// read exactly the bytes belonging to v without gob's own buffering
+// reading ahead into whatever follows on the stream (the snapshot body,
+// in the InstallSnapshot framing above).
+func writeMsg(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readMsg(r io.Reader, v interface{}) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(v)
+}
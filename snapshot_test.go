@@ -0,0 +1,91 @@
+package libp2praft
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestSnapshotRoutingTransportRoutesInstallSnapshot(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+
+	server, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer server: %v", err)
+	}
+	client, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer client: %v", err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatalf("LinkAll: %v", err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatalf("ConnectAllButSelf: %v", err)
+	}
+
+	serverTrans, _, err := NewLibp2pTransportWithSnapshotChannel(server, time.Second)
+	if err != nil {
+		t.Fatalf("NewLibp2pTransportWithSnapshotChannel: %v", err)
+	}
+	defer serverTrans.(raft.WithClose).Close()
+
+	body := []byte("snapshot body bytes")
+	wantResp := &raft.InstallSnapshotResponse{Term: 7, Success: true}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		rpc, ok := <-serverTrans.Consumer()
+		if !ok {
+			serverErr <- nil
+			return
+		}
+		req, ok := rpc.Command.(*raft.InstallSnapshotRequest)
+		if !ok {
+			serverErr <- io.ErrUnexpectedEOF
+			return
+		}
+		got, err := io.ReadAll(rpc.Reader)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		if !bytes.Equal(got, body) || req.Size != int64(len(body)) {
+			serverErr <- io.ErrShortBuffer
+			return
+		}
+		rpc.RespChan <- raft.RPCResponse{Response: wantResp}
+		serverErr <- nil
+	}()
+
+	clientSC := newSnapshotChannel(client, newTransportConfig(nil), time.Second)
+	defer clientSC.Close()
+
+	args := &raft.InstallSnapshotRequest{Term: wantResp.Term, Size: int64(len(body))}
+	var gotResp raft.InstallSnapshotResponse
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := clientSC.sendInstallSnapshot(ctx, server.ID(), args, &gotResp, bytes.NewReader(body)); err != nil {
+		t.Fatalf("sendInstallSnapshot: %v", err)
+	}
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			t.Fatalf("server side of the routed RPC failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("snapshotRoutingTransport never routed the InstallSnapshot RPC to Consumer()")
+	}
+
+	if gotResp.Term != wantResp.Term || gotResp.Success != wantResp.Success {
+		t.Fatalf("got response %+v, want %+v", gotResp, *wantResp)
+	}
+}
@@ -4,14 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net"
 	"time"
 
-	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
-	logging "github.com/ipfs/go-log/v2"
 	gostream "github.com/libp2p/go-libp2p-gostream"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -20,133 +16,15 @@ import (
 
 const RaftProtocol protocol.ID = "/raft/1.0.0/rpc"
 
-var raftLogger = logging.Logger("raftlib")
-
-// this implements github.com/hashicorp/go-hclog
-type hcLogToLogger struct {
-	extraArgs []interface{}
-	name      string
-}
-
-func (log *hcLogToLogger) formatArgs(args []interface{}) string {
-	result := ""
-	args = append(args, log.extraArgs)
-	for i := 0; i < len(args); i = i + 2 {
-		key, ok := args[i].(string)
-		if !ok {
-			continue
-		}
-		val := args[i+1]
-		result += fmt.Sprintf(" %s=%s.", key, val)
-	}
-	return result
-}
-
-func (log *hcLogToLogger) format(msg string, args []interface{}) string {
-	argstr := log.formatArgs(args)
-	if len(argstr) > 0 {
-		argstr = ". Args: " + argstr
-	}
-	name := log.name
-	if len(name) > 0 {
-		name += ": "
-	}
-	return name + msg + argstr
-}
-
-func (log *hcLogToLogger) Log(level hclog.Level, msg string, args ...interface{}) {
-	switch level {
-	case hclog.Trace, hclog.Debug:
-		log.Debug(msg, args)
-	case hclog.NoLevel, hclog.Info:
-		log.Info(msg, args)
-	case hclog.Warn:
-		log.Warn(msg, args)
-	case hclog.Error:
-		log.Error(msg, args)
-	default:
-		log.Warn(msg, args)
-	}
-}
-
-func (log *hcLogToLogger) Trace(msg string, args ...interface{}) {
-	raftLogger.Debug(log.format(msg, args))
-}
-
-func (log *hcLogToLogger) Debug(msg string, args ...interface{}) {
-	raftLogger.Debug(log.format(msg, args))
-}
-
-func (log *hcLogToLogger) Info(msg string, args ...interface{}) {
-	raftLogger.Info(log.format(msg, args))
-}
-
-func (log *hcLogToLogger) Warn(msg string, args ...interface{}) {
-	raftLogger.Warn(log.format(msg, args))
-}
-
-func (log *hcLogToLogger) Error(msg string, args ...interface{}) {
-	raftLogger.Error(log.format(msg, args))
-}
-
-func (log *hcLogToLogger) IsTrace() bool {
-	return true
-}
-
-func (log *hcLogToLogger) IsDebug() bool {
-	return true
-}
-
-func (log *hcLogToLogger) IsInfo() bool {
-	return true
-}
-
-func (log *hcLogToLogger) IsWarn() bool {
-	return true
-}
-
-func (log *hcLogToLogger) IsError() bool {
-	return true
-}
-
-func (log *hcLogToLogger) Name() string {
-	return log.name
-}
-
-func (log *hcLogToLogger) With(args ...interface{}) hclog.Logger {
-	return &hcLogToLogger{extraArgs: args}
-}
-
-func (log *hcLogToLogger) Named(name string) hclog.Logger {
-	return &hcLogToLogger{name: log.name + ": " + name}
-}
-
-func (log *hcLogToLogger) ResetNamed(name string) hclog.Logger {
-	return &hcLogToLogger{name: name}
-}
-
-func (log *hcLogToLogger) SetLevel(level hclog.Level) {}
-
-func (log *hcLogToLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
-	return nil
-}
-
-func (log *hcLogToLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
-	return nil
-}
-
-func (log *hcLogToLogger) ImpliedArgs() []interface{} {
-	return nil
-}
-
 // streamLayer an implementation of raft.StreamLayer for use
 // with raft.NetworkTransportConfig.
 type streamLayer struct {
 	host host.Host
 	l    net.Listener
+	cfg  *transportConfig
 }
 
-func newStreamLayer(h host.Host) (*streamLayer, error) {
+func newStreamLayer(h host.Host, cfg *transportConfig) (*streamLayer, error) {
 	listener, err := gostream.Listen(h, RaftProtocol)
 	if err != nil {
 		return nil, err
@@ -155,6 +33,7 @@ func newStreamLayer(h host.Host) (*streamLayer, error) {
 	return &streamLayer{
 		host: h,
 		l:    listener,
+		cfg:  cfg,
 	}, nil
 }
 
@@ -168,13 +47,48 @@ func (sl *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (
 		return nil, err
 	}
 
+	if !sl.cfg.authorize(pid) {
+		return nil, fmt.Errorf("peer %s is not authorized for %s", pid, RaftProtocol)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	return gostream.Dial(ctx, sl.host, pid, RaftProtocol)
+
+	start := time.Now()
+	conn, err := gostream.Dial(ctx, sl.host, pid, RaftProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	if sl.cfg.observer != nil {
+		sl.cfg.observer.StreamOpened(pid, ChannelRPC, time.Since(start))
+	}
+	return observeConn(conn, pid, ChannelRPC, sl.cfg.observer), nil
 }
 
+// Accept waits for and returns the next connection to the listener,
+// closing connections from peers that fail authorization, with only a
+// logged warning, so that a single rejected dial does not surface as a
+// transport error.
 func (sl *streamLayer) Accept() (net.Conn, error) {
-	return sl.l.Accept()
+	for {
+		conn, err := sl.l.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		pid, err := peer.Decode(conn.RemoteAddr().String())
+		if err != nil || !sl.cfg.authorize(pid) {
+			raftLogger.Warnf("rejecting unauthorized connection on %s from %s", RaftProtocol, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		if sl.cfg.observer != nil {
+			sl.cfg.observer.StreamOpened(pid, ChannelRPC, 0)
+		}
+		return observeConn(conn, pid, ChannelRPC, sl.cfg.observer), nil
+	}
 }
 
 func (sl *streamLayer) Addr() net.Addr {
@@ -201,26 +115,37 @@ func (ap *addrProvider) ServerAddr(id raft.ServerID) (raft.ServerAddress, error)
 // 	raftTrans *raft.NetworkTransport
 // }
 
-func NewLibp2pTransport(h host.Host, timeout time.Duration) (*raft.NetworkTransport, error) {
+// NewLibp2pTransport builds a raft.NetworkTransport that sends and
+// receives Raft RPCs over libp2p streams on RaftProtocol. By default any
+// peer the host will open a stream with is accepted; pass
+// WithAllowedPeers and/or WithConnAuthorizer to restrict that.
+func NewLibp2pTransport(h host.Host, timeout time.Duration, opts ...TransportOption) (*raft.NetworkTransport, error) {
+	tcfg := newTransportConfig(opts)
+
 	provider := &addrProvider{h}
-	stream, err := newStreamLayer(h)
+	stream, err := newStreamLayer(h, tcfg)
 	if err != nil {
 		return nil, err
 	}
 
 	// This is a configuration for raft.NetworkTransport
 	// initialized with our own StreamLayer and Logger.
-	// We set MaxPool to 0 so the NetworkTransport does not
-	// pool connections. This allows re-using already stablished
-	// TCP connections, for example, which are expensive to create.
-	// We are, however, multiplexing streams over an already created
-	// Libp2p connection, which is cheap. We don't need to re-use
-	// streams.
+	// By default MaxPool is 0 so the NetworkTransport does not pool
+	// connections: streams are multiplexed over an already created
+	// Libp2p connection, which is cheap, unlike TCP connections, so we
+	// don't need to re-use them. Under sustained load, though, newly
+	// opened streams can still contend with each other; pass
+	// WithPoolSize to keep dedicated streams open per remote instead.
+	logger := tcfg.logger
+	if logger == nil {
+		logger = newHCLogger(&raftLogger.SugaredLogger)
+	}
+
 	cfg := &raft.NetworkTransportConfig{
 		ServerAddressProvider: provider,
-		Logger:                &hcLogToLogger{},
+		Logger:                logger,
 		Stream:                stream,
-		MaxPool:               0,
+		MaxPool:               tcfg.poolSize,
 		Timeout:               timeout,
 	}
 